@@ -0,0 +1,42 @@
+package core
+
+import (
+	"errors"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// SessionCookieName is the cookie holding the transient Challenge for the
+// Hydra login/consent request in progress, as opposed to
+// UserSessionCookieName which holds a longer-lived authenticated session.
+const SessionCookieName = "idp_challenge"
+
+// ErrorBadRequest is returned when a request is missing something it
+// must carry, e.g. NewChallenge's "challenge" form value.
+var ErrorBadRequest = errors.New("bad request")
+
+// ErrorBadChallengeCookie is returned when the SessionCookieName cookie
+// doesn't carry a *Challenge, e.g. because it was never set or the store
+// holds something else under that key.
+var ErrorBadChallengeCookie = errors.New("bad challenge cookie")
+
+// Challenge is the in-flight Hydra login/consent request a handler walks
+// an end user through: which client and scopes it's for, where to send
+// them back, and which Connector should authenticate them.
+type Challenge struct {
+	Client    string
+	Redirect  string
+	Scopes    []string
+	Connector string
+	Expires   time.Time
+
+	// ID is the opaque login_challenge/consent_challenge Hydra v1.x/v2.x
+	// expects back on Accept/RejectLogin and Accept/RejectConsent. It's
+	// empty for the legacy Hydra 0.9 flow, which instead carries the
+	// original signed challenge JWT in token.
+	ID string
+
+	idp   *IDP
+	token *jwt.Token
+}
@@ -0,0 +1,180 @@
+package core
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrorUnknownScope is returned when a challenge requests a scope with no
+// known claim mapping and RejectUnknownScopes is set.
+var ErrorUnknownScope = errors.New("unknown scope")
+
+// ErrorAudienceNotAllowed is returned when a challenge requests an
+// "aud:<value>" scope that isn't in the client's ClientAudiences
+// allow-list.
+var ErrorAudienceNotAllowed = errors.New("audience not allowed for client")
+
+// resourceScopePrefix marks a requested scope as an RBAC resource scope
+// of the form "rbac:<resourceType>:<resourceID>:<role>", rather than a
+// plain OAuth2/OIDC scope.
+const resourceScopePrefix = "rbac:"
+
+// audienceScopePrefix marks a requested scope as a request for an
+// additional token audience, of the form "aud:<value>".
+const audienceScopePrefix = "aud:"
+
+// defaultScopeClaims is the built-in OIDC scope -> claims mapping.
+// ScopeClaims in IDPConfig is merged on top of this.
+var defaultScopeClaims = map[string][]string{
+	"openid":  {"sub"},
+	"profile": {"name", "given_name", "family_name"},
+	"email":   {"email", "email_verified"},
+	"groups":  {"groups"},
+}
+
+// ClaimProvider fetches the value of a set of claims for subject, e.g.
+// from LDAP, a SQL user table, or an HTTP user-info endpoint.
+type ClaimProvider interface {
+	Claims(subject string, names []string) (map[string]interface{}, error)
+}
+
+// ResourceScope describes a scope-restricted downstream token: the
+// resource type and ID the bearer may act on, and in what role. It's
+// embedded in the consent JWT's "scope" claim so relying parties can
+// enforce RBAC without a second lookup.
+type ResourceScope struct {
+	ResourceType string `json:"resource_type"`
+	ResourceID   string `json:"resource_id"`
+	Role         string `json:"role"`
+}
+
+// parseResourceScope parses a "rbac:<type>:<id>:<role>" scope string.
+func parseResourceScope(scope string) (ResourceScope, bool) {
+	if !strings.HasPrefix(scope, resourceScopePrefix) {
+		return ResourceScope{}, false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(scope, resourceScopePrefix), ":", 3)
+	if len(parts) != 3 {
+		return ResourceScope{}, false
+	}
+
+	return ResourceScope{ResourceType: parts[0], ResourceID: parts[1], Role: parts[2]}, true
+}
+
+// scopeClaims returns the effective scope -> claims mapping: the built-in
+// defaults overridden/extended by config.ScopeClaims.
+func (idp *IDP) scopeClaims() map[string][]string {
+	if len(idp.config.ScopeClaims) == 0 {
+		return defaultScopeClaims
+	}
+
+	merged := make(map[string][]string, len(defaultScopeClaims)+len(idp.config.ScopeClaims))
+	for scope, claims := range defaultScopeClaims {
+		merged[scope] = claims
+	}
+	for scope, claims := range idp.config.ScopeClaims {
+		merged[scope] = claims
+	}
+
+	return merged
+}
+
+// buildConsentClaims turns the scopes requested by a challenge into the
+// token scopes, extra ID-token claims and resource scopes that belong in
+// the consent JWT for client/subject, rejecting or dropping unrequested
+// scopes per RejectUnknownScopes.
+func (idp *IDP) buildConsentClaims(client, subject string, scopes []string) (tokenScopes []string, claims map[string]interface{}, err error) {
+	scopeClaims := idp.scopeClaims()
+	claims = make(map[string]interface{})
+
+	var claimNames []string
+	var resourceScopes []ResourceScope
+	var audiences []string
+
+	for _, scope := range scopes {
+		if resourceScope, ok := parseResourceScope(scope); ok {
+			resourceScopes = append(resourceScopes, resourceScope)
+			continue
+		}
+
+		if strings.HasPrefix(scope, audienceScopePrefix) {
+			aud := strings.TrimPrefix(scope, audienceScopePrefix)
+			if !idp.audienceAllowed(client, aud) {
+				return nil, nil, ErrorAudienceNotAllowed
+			}
+			audiences = append(audiences, aud)
+			tokenScopes = append(tokenScopes, scope)
+			continue
+		}
+
+		names, known := scopeClaims[scope]
+		if !known {
+			if idp.config.RejectUnknownScopes {
+				return nil, nil, ErrorUnknownScope
+			}
+			continue
+		}
+
+		claimNames = append(claimNames, names...)
+		tokenScopes = append(tokenScopes, scope)
+	}
+
+	if len(claimNames) > 0 {
+		if idp.config.ClaimProvider == nil {
+			return nil, nil, errors.New("core: scope requires a claim but no ClaimProvider is configured")
+		}
+
+		values, err := idp.config.ClaimProvider.Claims(subject, claimNames)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for k, v := range values {
+			claims[k] = v
+		}
+	}
+
+	if len(resourceScopes) > 0 {
+		claims["scope"] = resourceScopes
+	}
+
+	if len(audiences) > 0 {
+		claims["aud"] = append(audiences, client)
+	}
+
+	if acr, ok := idp.config.ClientACR[client]; ok {
+		claims["acr"] = acr
+	}
+
+	return tokenScopes, claims, nil
+}
+
+// audienceAllowed reports whether client may request aud as an
+// additional token audience.
+func (idp *IDP) audienceAllowed(client, aud string) bool {
+	for _, allowed := range idp.config.ClientAudiences[client] {
+		if allowed == aud {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GenerateConsentToken builds and signs the consent JWT that completes
+// challenge for subject, mapping requested scopes to ID-token claims,
+// restricting audiences, and tagging the authentication method used
+// (amr) via connector.
+func (idp *IDP) GenerateConsentToken(challenge *Challenge, subject, connector string) (string, error) {
+	tokenScopes, claims, err := idp.buildConsentClaims(challenge.Client, subject, challenge.Scopes)
+	if err != nil {
+		return "", err
+	}
+
+	if connector != "" {
+		claims["amr"] = []string{connector}
+	}
+
+	return idp.generateConsentToken(challenge.Client, subject, tokenScopes, claims)
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+type stubClaimProvider struct {
+	values map[string]interface{}
+	err    error
+}
+
+func (s *stubClaimProvider) Claims(subject string, names []string) (map[string]interface{}, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	out := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		if v, ok := s.values[name]; ok {
+			out[name] = v
+		}
+	}
+
+	return out, nil
+}
+
+func TestBuildConsentClaimsDefaultScopes(t *testing.T) {
+	idp := &IDP{config: &IDPConfig{
+		ClaimProvider: &stubClaimProvider{values: map[string]interface{}{
+			"sub":   "alice",
+			"email": "alice@example.com",
+		}},
+	}}
+
+	tokenScopes, claims, err := idp.buildConsentClaims("client1", "alice", []string{"openid", "email"})
+	if err != nil {
+		t.Fatalf("buildConsentClaims returned error: %v", err)
+	}
+
+	if !reflect.DeepEqual(tokenScopes, []string{"openid", "email"}) {
+		t.Errorf("tokenScopes = %v, want [openid email]", tokenScopes)
+	}
+
+	if claims["sub"] != "alice" || claims["email"] != "alice@example.com" {
+		t.Errorf("claims = %v, missing expected sub/email", claims)
+	}
+}
+
+func TestBuildConsentClaimsUnknownScope(t *testing.T) {
+	idp := &IDP{config: &IDPConfig{RejectUnknownScopes: true}}
+
+	if _, _, err := idp.buildConsentClaims("client1", "alice", []string{"bogus"}); err != ErrorUnknownScope {
+		t.Errorf("err = %v, want ErrorUnknownScope", err)
+	}
+
+	idp.config.RejectUnknownScopes = false
+	tokenScopes, claims, err := idp.buildConsentClaims("client1", "alice", []string{"bogus"})
+	if err != nil {
+		t.Fatalf("buildConsentClaims returned error: %v", err)
+	}
+	if len(tokenScopes) != 0 || len(claims) != 0 {
+		t.Errorf("unknown scope should be silently dropped, got tokenScopes=%v claims=%v", tokenScopes, claims)
+	}
+}
+
+func TestBuildConsentClaimsResourceScope(t *testing.T) {
+	idp := &IDP{config: &IDPConfig{}}
+
+	_, claims, err := idp.buildConsentClaims("client1", "alice", []string{"rbac:project:42:admin"})
+	if err != nil {
+		t.Fatalf("buildConsentClaims returned error: %v", err)
+	}
+
+	want := []ResourceScope{{ResourceType: "project", ResourceID: "42", Role: "admin"}}
+	if !reflect.DeepEqual(claims["scope"], want) {
+		t.Errorf("claims[scope] = %v, want %v", claims["scope"], want)
+	}
+}
+
+func TestBuildConsentClaimsAudienceScope(t *testing.T) {
+	idp := &IDP{config: &IDPConfig{
+		ClientAudiences: map[string][]string{"client1": {"downstream"}},
+	}}
+
+	tokenScopes, claims, err := idp.buildConsentClaims("client1", "alice", []string{"aud:downstream"})
+	if err != nil {
+		t.Fatalf("buildConsentClaims returned error: %v", err)
+	}
+
+	if !reflect.DeepEqual(tokenScopes, []string{"aud:downstream"}) {
+		t.Errorf("tokenScopes = %v, want [aud:downstream]", tokenScopes)
+	}
+
+	if !reflect.DeepEqual(claims["aud"], []string{"downstream", "client1"}) {
+		t.Errorf("claims[aud] = %v, want [downstream client1]", claims["aud"])
+	}
+}
+
+func TestBuildConsentClaimsAudienceNotAllowed(t *testing.T) {
+	idp := &IDP{config: &IDPConfig{}}
+
+	if _, _, err := idp.buildConsentClaims("client1", "alice", []string{"aud:downstream"}); err != ErrorAudienceNotAllowed {
+		t.Errorf("err = %v, want ErrorAudienceNotAllowed", err)
+	}
+}
+
+func TestBuildConsentClaimsMissingClaimProvider(t *testing.T) {
+	idp := &IDP{config: &IDPConfig{}}
+
+	if _, _, err := idp.buildConsentClaims("client1", "alice", []string{"openid"}); err == nil {
+		t.Error("expected error when a scope requires a claim but no ClaimProvider is configured")
+	}
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrorUnknownConnector is returned when a challenge can't be routed to
+// any configured Connector.
+var ErrorUnknownConnector = errors.New("unknown connector")
+
+// Identity is what a Connector produces once the end user has
+// authenticated against the upstream identity provider it wraps.
+type Identity struct {
+	Subject string
+	Email   string
+	Groups  []string
+
+	// Claims carries any provider-specific attributes that don't map to
+	// the fields above, merged into the consent token verbatim.
+	Claims map[string]interface{}
+}
+
+// claims returns the set of extra claims that should be merged into the
+// consent JWT alongside sub/aud/exp/iat/scp.
+func (i Identity) claims() map[string]interface{} {
+	out := make(map[string]interface{}, len(i.Claims)+2)
+	for k, v := range i.Claims {
+		out[k] = v
+	}
+
+	if i.Email != "" {
+		out["email"] = i.Email
+	}
+
+	if len(i.Groups) > 0 {
+		out["groups"] = i.Groups
+	}
+
+	return out
+}
+
+// Connector delegates authentication of the end user to an upstream
+// identity provider. NewChallenge picks the Connector to use for a given
+// login; HandleCallback is expected to be wired behind whatever route the
+// connector's LoginURL redirects back to.
+type Connector interface {
+	// LoginURL returns the URL to redirect the end user's browser to in
+	// order to start a login at the upstream provider. state is opaque
+	// and must be returned unchanged in the callback.
+	LoginURL(state string) string
+
+	// HandleCallback completes the login started by LoginURL, reading
+	// whatever the upstream provider put on r (a code, an assertion, a
+	// ticket, ...) and returning the resulting Identity.
+	HandleCallback(r *http.Request) (Identity, error)
+
+	// Refresh re-validates or renews identity against the upstream
+	// provider, e.g. for a connector that only trusts short-lived
+	// assertions.
+	Refresh(identity Identity) (Identity, error)
+}
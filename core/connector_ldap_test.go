@@ -0,0 +1,37 @@
+package core
+
+import "testing"
+
+func TestEscapeLDAPDN(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"alice", "alice"},
+		{"alice,ou=evil", `alice\,ou=evil`},
+		{"a+b", `a\+b`},
+		{`a"b`, `a\"b`},
+		{"a<b>c", `a\<b\>c`},
+		{"a;b", `a\;b`},
+		{"a=b", `a\=b`},
+		{"#alice", `\#alice`},
+		{" alice", `\ alice`},
+		{"alice ", `alice\ `},
+	}
+
+	for _, tt := range tests {
+		if got := escapeLDAPDN(tt.in); got != tt.want {
+			t.Errorf("escapeLDAPDN(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHandleCallbackRejectsEmptyPassword(t *testing.T) {
+	c := &ldapConnector{config: LDAPConfig{BindDN: "uid=%s,dc=example,dc=com"}}
+
+	r := httptestPostForm(t, map[string]string{"username": "alice", "password": ""})
+
+	if _, err := c.HandleCallback(r); err != ErrorBadRequest {
+		t.Errorf("err = %v, want ErrorBadRequest for an empty password", err)
+	}
+}
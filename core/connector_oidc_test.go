@@ -0,0 +1,26 @@
+package core
+
+import "testing"
+
+func TestAudienceContains(t *testing.T) {
+	tests := []struct {
+		name     string
+		aud      interface{}
+		clientID string
+		want     bool
+	}{
+		{"matching string aud", "client1", "client1", true},
+		{"non-matching string aud", "client2", "client1", false},
+		{"matching entry in array aud", []interface{}{"client2", "client1"}, "client1", true},
+		{"no matching entry in array aud", []interface{}{"client2", "client3"}, "client1", false},
+		{"unsupported aud type", 42, "client1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := audienceContains(tt.aud, tt.clientID); got != tt.want {
+				t.Errorf("audienceContains(%v, %q) = %v, want %v", tt.aud, tt.clientID, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrorInvalidCredentials is returned by PasswordStore.Authenticate when
+// the username/password pair doesn't match.
+var ErrorInvalidCredentials = errors.New("invalid credentials")
+
+// PasswordStore validates local username/password credentials for
+// passwordConnector.
+type PasswordStore interface {
+	Authenticate(username, password string) (Identity, error)
+}
+
+// passwordConnector is the local, non-delegated Connector: it validates
+// credentials posted straight to this IDP instead of redirecting to an
+// upstream provider.
+type passwordConnector struct {
+	store    PasswordStore
+	loginURI string
+}
+
+// NewPasswordConnector returns a Connector that authenticates against
+// store. loginURI is the local login form LoginURL points to.
+func NewPasswordConnector(store PasswordStore, loginURI string) Connector {
+	return &passwordConnector{store: store, loginURI: loginURI}
+}
+
+func (c *passwordConnector) LoginURL(state string) string {
+	return c.loginURI + "?state=" + state
+}
+
+func (c *passwordConnector) HandleCallback(r *http.Request) (Identity, error) {
+	if err := r.ParseForm(); err != nil {
+		return Identity{}, err
+	}
+
+	return c.store.Authenticate(r.FormValue("username"), r.FormValue("password"))
+}
+
+func (c *passwordConnector) Refresh(identity Identity) (Identity, error) {
+	// Local credentials don't expire out from under us between logins.
+	return identity, nil
+}
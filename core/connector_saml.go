@@ -0,0 +1,146 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+// samlRequestIDTTL bounds how long an outstanding AuthnRequest is
+// tracked: LoginURL sweeps entries older than this on every call, so an
+// initiated-but-never-completed login doesn't grow requestIDs forever.
+const samlRequestIDTTL = 10 * time.Minute
+
+// SAMLConfig configures samlConnector against a SAML 2.0 identity
+// provider.
+type SAMLConfig struct {
+	IDPMetadataURL string `yaml:"idp_metadata_url"`
+	SPEntityID     string `yaml:"sp_entity_id"`
+	SPACSURL       string `yaml:"sp_acs_url"`
+
+	// GroupsAttr and EmailAttr are the SAML attribute names carrying
+	// group membership and email in the assertion.
+	GroupsAttr string `yaml:"groups_attr"`
+	EmailAttr  string `yaml:"email_attr"`
+}
+
+// samlConnector authenticates end users via a SAML 2.0 SP-initiated
+// login, verifying the IdP's assertion on callback.
+type samlConnector struct {
+	config          SAMLConfig
+	serviceProvider *saml.ServiceProvider
+
+	mu         sync.Mutex
+	requestIDs map[string]samlRequestID // state -> outstanding AuthnRequest
+}
+
+// samlRequestID is what requestIDs tracks for one outstanding
+// AuthnRequest: the ID to validate the eventual assertion's InResponseTo
+// against, and when it was issued so LoginURL can sweep it once stale.
+type samlRequestID struct {
+	id        string
+	createdAt time.Time
+}
+
+// NewSAMLConnector builds a Connector from an already-fetched IdP
+// metadata document and the given SP settings.
+func NewSAMLConnector(config SAMLConfig, idpMetadata *saml.EntityDescriptor) (Connector, error) {
+	acsURL, err := url.Parse(config.SPACSURL)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &saml.ServiceProvider{
+		EntityID:    config.SPEntityID,
+		AcsURL:      *acsURL,
+		IDPMetadata: idpMetadata,
+	}
+
+	return &samlConnector{config: config, serviceProvider: sp, requestIDs: make(map[string]samlRequestID)}, nil
+}
+
+func (c *samlConnector) LoginURL(state string) string {
+	req, err := c.serviceProvider.MakeAuthenticationRequest(c.serviceProvider.GetSSOBindingLocation(saml.HTTPRedirectBinding))
+	if err != nil {
+		return ""
+	}
+
+	u, err := req.Redirect(state)
+	if err != nil {
+		return ""
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	for s, entry := range c.requestIDs {
+		if now.Sub(entry.createdAt) > samlRequestIDTTL {
+			delete(c.requestIDs, s)
+		}
+	}
+	c.requestIDs[state] = samlRequestID{id: req.ID, createdAt: now}
+	c.mu.Unlock()
+
+	return u.String()
+}
+
+func (c *samlConnector) HandleCallback(r *http.Request) (Identity, error) {
+	if err := r.ParseForm(); err != nil {
+		return Identity{}, err
+	}
+
+	state := r.FormValue("RelayState")
+
+	c.mu.Lock()
+	entry, ok := c.requestIDs[state]
+	if ok {
+		delete(c.requestIDs, state)
+	}
+	c.mu.Unlock()
+
+	if !ok || time.Since(entry.createdAt) > samlRequestIDTTL {
+		return Identity{}, fmt.Errorf("saml: no outstanding AuthnRequest for state %q", state)
+	}
+
+	assertion, err := c.serviceProvider.ParseResponse(r, []string{entry.id})
+	if err != nil {
+		return Identity{}, fmt.Errorf("saml: invalid assertion: %w", err)
+	}
+
+	identity := Identity{Claims: map[string]interface{}{}}
+
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			values := make([]string, len(attr.Values))
+			for i, v := range attr.Values {
+				values[i] = v.Value
+			}
+
+			switch attr.Name {
+			case c.config.EmailAttr:
+				if len(values) > 0 {
+					identity.Email = values[0]
+				}
+			case c.config.GroupsAttr:
+				identity.Groups = values
+			default:
+				identity.Claims[attr.Name] = values
+			}
+		}
+	}
+
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		identity.Subject = assertion.Subject.NameID.Value
+	}
+
+	return identity, nil
+}
+
+func (c *samlConnector) Refresh(identity Identity) (Identity, error) {
+	// SAML assertions are one-shot; there's nothing to refresh against
+	// without a full re-authentication.
+	return identity, nil
+}
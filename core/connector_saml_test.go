@@ -0,0 +1,58 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+func newTestSAMLConnector() *samlConnector {
+	return &samlConnector{
+		serviceProvider: &saml.ServiceProvider{},
+		requestIDs:      make(map[string]samlRequestID),
+	}
+}
+
+func TestHandleCallbackRejectsUntrackedRelayState(t *testing.T) {
+	c := newTestSAMLConnector()
+
+	r := httptestPostForm(t, map[string]string{"RelayState": "unknown-state"})
+
+	_, err := c.HandleCallback(r)
+	if err == nil || !strings.Contains(err.Error(), "no outstanding AuthnRequest") {
+		t.Errorf("err = %v, want a \"no outstanding AuthnRequest\" error", err)
+	}
+}
+
+func TestHandleCallbackConsumesRequestID(t *testing.T) {
+	c := newTestSAMLConnector()
+	c.requestIDs["state1"] = samlRequestID{id: "req1", createdAt: time.Now()}
+
+	r := httptestPostForm(t, map[string]string{"RelayState": "state1"})
+
+	// The SAMLResponse itself is missing, so this is expected to fail
+	// downstream in ParseResponse; what matters here is that the tracked
+	// request ID was looked up and consumed rather than rejected
+	// outright as untracked.
+	if _, err := c.HandleCallback(r); err == nil {
+		t.Fatal("expected an error from ParseResponse given no SAMLResponse was submitted")
+	}
+
+	if _, ok := c.requestIDs["state1"]; ok {
+		t.Error("HandleCallback didn't consume the tracked request ID for state1")
+	}
+}
+
+func TestHandleCallbackRejectsExpiredRequestID(t *testing.T) {
+	c := newTestSAMLConnector()
+	c.requestIDs["state1"] = samlRequestID{id: "req1", createdAt: time.Now().Add(-samlRequestIDTTL - time.Minute)}
+
+	r := httptestPostForm(t, map[string]string{"RelayState": "state1"})
+
+	_, err := c.HandleCallback(r)
+	if err == nil || !strings.Contains(err.Error(), "no outstanding AuthnRequest") {
+		t.Errorf("err = %v, want a \"no outstanding AuthnRequest\" error for a stale request ID", err)
+	}
+}
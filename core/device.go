@@ -0,0 +1,356 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	// deviceCodeTTL is how long a device/user code pair stays valid
+	// before the device must request a new one.
+	deviceCodeTTL = 10 * time.Minute
+
+	// devicePollInterval is the minimum number of seconds a device must
+	// wait between two polls of /device/token, per RFC 8628 section 3.5.
+	devicePollInterval = 5
+
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+// Device authorization errors, returned by PollDeviceToken and mapped by
+// the /device/token handler to the error codes from RFC 8628 section 3.5.
+var (
+	ErrorAuthorizationPending = errors.New("authorization_pending")
+	ErrorSlowDown             = errors.New("slow_down")
+	ErrorAccessDenied         = errors.New("access_denied")
+	ErrorExpiredToken         = errors.New("expired_token")
+	ErrorUnknownDeviceCode    = errors.New("unknown device_code")
+	ErrorUnknownUserCode      = errors.New("unknown user_code")
+)
+
+// DeviceAuthorization is the response to POST /device/authorize.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceCode is what a DeviceCodeStore keeps for the lifetime of one
+// device authorization request.
+type DeviceCode struct {
+	DeviceCode string
+	UserCode   string
+	ClientID   string
+	Scopes     []string
+
+	Subject  string
+	Approved bool
+	Denied   bool
+
+	ExpiresAt  time.Time
+	LastPolled time.Time
+}
+
+func (e *DeviceCode) expired() bool {
+	return time.Now().After(e.ExpiresAt)
+}
+
+// DeviceCodeStore persists in-flight device authorization requests, keyed
+// by both device_code (polled by the device) and user_code (entered by the
+// user in a browser).
+type DeviceCodeStore interface {
+	Save(entry *DeviceCode) error
+	GetByDeviceCode(deviceCode string) (*DeviceCode, error)
+	GetByUserCode(userCode string) (*DeviceCode, error)
+	Update(entry *DeviceCode) error
+	Delete(deviceCode string) error
+}
+
+// memoryDeviceCodeStore is a DeviceCodeStore backed by an in-process map.
+type memoryDeviceCodeStore struct {
+	mu     sync.Mutex
+	byCode map[string]*DeviceCode
+	byUser map[string]string
+}
+
+// NewMemoryDeviceCodeStore returns a DeviceCodeStore that keeps device
+// authorization requests in memory.
+func NewMemoryDeviceCodeStore() DeviceCodeStore {
+	return &memoryDeviceCodeStore{
+		byCode: make(map[string]*DeviceCode),
+		byUser: make(map[string]string),
+	}
+}
+
+func (s *memoryDeviceCodeStore) Save(entry *DeviceCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.byCode[entry.DeviceCode] = entry
+	s.byUser[entry.UserCode] = entry.DeviceCode
+
+	return nil
+}
+
+func (s *memoryDeviceCodeStore) GetByDeviceCode(deviceCode string) (*DeviceCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.byCode[deviceCode]
+	if !ok {
+		return nil, ErrorUnknownDeviceCode
+	}
+
+	return entry, nil
+}
+
+func (s *memoryDeviceCodeStore) GetByUserCode(userCode string) (*DeviceCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deviceCode, ok := s.byUser[userCode]
+	if !ok {
+		return nil, ErrorUnknownUserCode
+	}
+
+	return s.byCode[deviceCode], nil
+}
+
+func (s *memoryDeviceCodeStore) Update(entry *DeviceCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byCode[entry.DeviceCode]; !ok {
+		return ErrorUnknownDeviceCode
+	}
+
+	s.byCode[entry.DeviceCode] = entry
+
+	return nil
+}
+
+func (s *memoryDeviceCodeStore) Delete(deviceCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.byCode[deviceCode]
+	if !ok {
+		return nil
+	}
+
+	delete(s.byCode, deviceCode)
+	delete(s.byUser, entry.UserCode)
+
+	return nil
+}
+
+// redisDeviceCodeStore is a DeviceCodeStore backed by Redis, with entries
+// expiring on their own via the TTL set on the device_code/user_code keys.
+type redisDeviceCodeStore struct {
+	client *redis.Client
+}
+
+// NewRedisDeviceCodeStore returns a DeviceCodeStore backed by client.
+func NewRedisDeviceCodeStore(client *redis.Client) DeviceCodeStore {
+	return &redisDeviceCodeStore{client: client}
+}
+
+func (s *redisDeviceCodeStore) deviceKey(deviceCode string) string {
+	return "device:code:" + deviceCode
+}
+func (s *redisDeviceCodeStore) userKey(userCode string) string { return "device:user:" + userCode }
+
+func (s *redisDeviceCodeStore) Save(entry *DeviceCode) error {
+	ttl := time.Until(entry.ExpiresAt)
+
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := s.client.Set(s.userKey(entry.UserCode), entry.DeviceCode, ttl).Err(); err != nil {
+		return err
+	}
+
+	return s.client.Set(s.deviceKey(entry.DeviceCode), encoded, ttl).Err()
+}
+
+func (s *redisDeviceCodeStore) GetByDeviceCode(deviceCode string) (*DeviceCode, error) {
+	raw, err := s.client.Get(s.deviceKey(deviceCode)).Bytes()
+	if err != nil {
+		return nil, ErrorUnknownDeviceCode
+	}
+
+	var entry DeviceCode
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+func (s *redisDeviceCodeStore) GetByUserCode(userCode string) (*DeviceCode, error) {
+	deviceCode, err := s.client.Get(s.userKey(userCode)).Result()
+	if err != nil {
+		return nil, ErrorUnknownUserCode
+	}
+
+	entry, err := s.GetByDeviceCode(deviceCode)
+	if err != nil {
+		return nil, ErrorUnknownUserCode
+	}
+
+	return entry, nil
+}
+
+func (s *redisDeviceCodeStore) Update(entry *DeviceCode) error {
+	return s.Save(entry)
+}
+
+func (s *redisDeviceCodeStore) Delete(deviceCode string) error {
+	return s.client.Del(s.deviceKey(deviceCode)).Err()
+}
+
+// randomCode generates a URL-safe random string of n bytes, hex-encoded.
+func randomCode(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", buf), nil
+}
+
+// randomUserCode generates an "XXXX-XXXX" style user code using a
+// restricted alphabet that avoids visually ambiguous characters.
+func randomUserCode() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for i, b := range buf {
+		if i == 4 {
+			sb.WriteByte('-')
+		}
+		sb.WriteByte(userCodeAlphabet[int(b)%len(userCodeAlphabet)])
+	}
+
+	return sb.String(), nil
+}
+
+// NewDeviceAuthorization starts a device authorization request for
+// clientID, storing it in the configured DeviceCodeStore and returning the
+// response for POST /device/authorize.
+func (idp *IDP) NewDeviceAuthorization(clientID string, scopes []string) (*DeviceAuthorization, error) {
+	deviceCode, err := randomCode(32)
+	if err != nil {
+		return nil, err
+	}
+
+	userCode, err := randomUserCode()
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &DeviceCode{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ClientID:   clientID,
+		Scopes:     scopes,
+		ExpiresAt:  time.Now().Add(deviceCodeTTL),
+	}
+
+	if err := idp.config.DeviceCodeStore.Save(entry); err != nil {
+		return nil, err
+	}
+
+	verificationURI := idp.config.DeviceVerificationURI
+
+	return &DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + userCode,
+		ExpiresIn:               int(deviceCodeTTL.Seconds()),
+		Interval:                devicePollInterval,
+	}, nil
+}
+
+// ApproveDeviceCode pairs userCode to subject/scopes, called once the user
+// has completed the consent challenge for a device pairing in their
+// browser. Rejecting is the same call with approve set to false.
+func (idp *IDP) ApproveDeviceCode(userCode, subject string, approve bool) error {
+	entry, err := idp.config.DeviceCodeStore.GetByUserCode(userCode)
+	if err != nil {
+		return err
+	}
+
+	if entry.expired() {
+		return ErrorExpiredToken
+	}
+
+	entry.Subject = subject
+	entry.Approved = approve
+	entry.Denied = !approve
+
+	return idp.config.DeviceCodeStore.Update(entry)
+}
+
+// PollDeviceToken implements the polling semantics of POST /device/token:
+// it returns a signed consent token once the device code has been
+// approved, or one of ErrorAuthorizationPending, ErrorSlowDown,
+// ErrorAccessDenied or ErrorExpiredToken otherwise.
+func (idp *IDP) PollDeviceToken(deviceCode string) (string, error) {
+	entry, err := idp.config.DeviceCodeStore.GetByDeviceCode(deviceCode)
+	if err != nil {
+		return "", ErrorUnknownDeviceCode
+	}
+
+	if entry.expired() {
+		idp.config.DeviceCodeStore.Delete(deviceCode)
+		return "", ErrorExpiredToken
+	}
+
+	if !entry.LastPolled.IsZero() && time.Since(entry.LastPolled) < devicePollInterval*time.Second {
+		return "", ErrorSlowDown
+	}
+	entry.LastPolled = time.Now()
+	idp.config.DeviceCodeStore.Update(entry)
+
+	if entry.Denied {
+		idp.config.DeviceCodeStore.Delete(deviceCode)
+		return "", ErrorAccessDenied
+	}
+
+	if !entry.Approved {
+		return "", ErrorAuthorizationPending
+	}
+
+	// One-shot exchange: the device code can't be polled for a second
+	// token once it has been claimed.
+	idp.config.DeviceCodeStore.Delete(deviceCode)
+
+	tokenScopes, claims, err := idp.buildConsentClaims(entry.ClientID, entry.Subject, entry.Scopes)
+	if err != nil {
+		return "", err
+	}
+
+	claims["amr"] = []string{"device"}
+	if _, ok := claims["aud"]; !ok {
+		claims["aud"] = entry.ClientID
+	}
+
+	return idp.generateConsentToken(entry.ClientID, entry.Subject, tokenScopes, claims)
+}
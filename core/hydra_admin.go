@@ -0,0 +1,60 @@
+package core
+
+import "errors"
+
+// ErrorNotSupported is returned by a HydraAdmin implementation for an
+// operation its Hydra version doesn't have, e.g. RejectLoginRequest
+// against the Hydra 0.9 consent-JWT flow.
+var ErrorNotSupported = errors.New("operation not supported by this HydraAdmin implementation")
+
+// LoginRequest is the subset of Hydra's login request that this IDP acts
+// on: who is authenticating, for which client, and whether Hydra already
+// has an active session for the subject (Skip).
+type LoginRequest struct {
+	Challenge      string
+	Client         string
+	RequestedScope []string
+	Subject        string
+	Skip           bool
+}
+
+// ConsentRequest is the subset of Hydra's consent request that this IDP
+// acts on.
+type ConsentRequest struct {
+	Challenge         string
+	Client            string
+	Subject           string
+	RequestedScope    []string
+	RequestedAudience []string
+}
+
+// LogoutRequest is the subset of Hydra's logout request that this IDP
+// acts on.
+type LogoutRequest struct {
+	Challenge string
+	Subject   string
+}
+
+// ConsentSession carries the claims to embed in the ID token and access
+// token Hydra issues once a consent request is accepted.
+type ConsentSession struct {
+	IDToken     map[string]interface{}
+	AccessToken map[string]interface{}
+}
+
+// HydraAdmin abstracts Hydra's admin API for the login+consent challenge
+// model used by Hydra v1.x/v2.x, so this IDP can also keep talking to the
+// deprecated Hydra 0.9 consent-JWT flow behind the same interface. See
+// NewHTTPHydraAdmin and newLegacyHydraAdmin for the two implementations.
+type HydraAdmin interface {
+	GetLoginRequest(challenge string) (*LoginRequest, error)
+	AcceptLoginRequest(challenge, subject string, remember bool, acr string) (redirectTo string, err error)
+	RejectLoginRequest(challenge, errorCode, errorDescription string) (redirectTo string, err error)
+
+	GetConsentRequest(challenge string) (*ConsentRequest, error)
+	AcceptConsentRequest(challenge string, grantScope, grantAudience []string, session ConsentSession, remember bool) (redirectTo string, err error)
+	RejectConsentRequest(challenge, errorCode, errorDescription string) (redirectTo string, err error)
+
+	GetLogoutRequest(challenge string) (*LogoutRequest, error)
+	AcceptLogoutRequest(challenge string) (redirectTo string, err error)
+}
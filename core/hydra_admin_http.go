@@ -0,0 +1,174 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// httpHydraAdmin implements HydraAdmin against Hydra's modern REST admin
+// API, shared (modulo the URL prefix) by v1.x and v2.x.
+type httpHydraAdmin struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPHydraAdmin returns a HydraAdmin that talks to the login/consent
+// challenge REST API exposed by Hydra v1.x and v2.x. baseURL is Hydra's
+// admin API base, e.g. "https://hydra-admin:4445" for v1.x or
+// "https://hydra-admin:4445/admin" for v2.x.
+func NewHTTPHydraAdmin(baseURL string, client *http.Client) HydraAdmin {
+	return &httpHydraAdmin{baseURL: baseURL, client: client}
+}
+
+type hydraLoginRequest struct {
+	Challenge string `json:"challenge"`
+	Skip      bool   `json:"skip"`
+	Subject   string `json:"subject"`
+	Client    struct {
+		ClientID string `json:"client_id"`
+	} `json:"client"`
+	RequestedScope []string `json:"requested_scope"`
+}
+
+type hydraConsentRequest struct {
+	Challenge string `json:"challenge"`
+	Subject   string `json:"subject"`
+	Client    struct {
+		ClientID string `json:"client_id"`
+	} `json:"client"`
+	RequestedScope    []string `json:"requested_scope"`
+	RequestedAudience []string `json:"requested_access_token_audience"`
+}
+
+type hydraLogoutRequest struct {
+	Challenge string `json:"challenge"`
+	Subject   string `json:"subject"`
+}
+
+type hydraRedirect struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+func (a *httpHydraAdmin) get(path string, out interface{}) error {
+	resp, err := a.client.Get(a.baseURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("hydra admin: GET %s: %s", path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (a *httpHydraAdmin) put(path string, body interface{}) (string, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, a.baseURL+path, bytes.NewReader(encoded))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("hydra admin: PUT %s: %s", path, resp.Status)
+	}
+
+	var redirect hydraRedirect
+	if err := json.NewDecoder(resp.Body).Decode(&redirect); err != nil {
+		return "", err
+	}
+
+	return redirect.RedirectTo, nil
+}
+
+func (a *httpHydraAdmin) GetLoginRequest(challenge string) (*LoginRequest, error) {
+	var req hydraLoginRequest
+	if err := a.get("/oauth2/auth/requests/login?login_challenge="+challenge, &req); err != nil {
+		return nil, err
+	}
+
+	return &LoginRequest{
+		Challenge:      challenge,
+		Client:         req.Client.ClientID,
+		RequestedScope: req.RequestedScope,
+		Subject:        req.Subject,
+		Skip:           req.Skip,
+	}, nil
+}
+
+func (a *httpHydraAdmin) AcceptLoginRequest(challenge, subject string, remember bool, acr string) (string, error) {
+	return a.put("/oauth2/auth/requests/login/accept?login_challenge="+challenge, map[string]interface{}{
+		"subject":      subject,
+		"remember":     remember,
+		"remember_for": 0,
+		"acr":          acr,
+	})
+}
+
+func (a *httpHydraAdmin) RejectLoginRequest(challenge, errorCode, errorDescription string) (string, error) {
+	return a.put("/oauth2/auth/requests/login/reject?login_challenge="+challenge, map[string]interface{}{
+		"error":             errorCode,
+		"error_description": errorDescription,
+	})
+}
+
+func (a *httpHydraAdmin) GetConsentRequest(challenge string) (*ConsentRequest, error) {
+	var req hydraConsentRequest
+	if err := a.get("/oauth2/auth/requests/consent?consent_challenge="+challenge, &req); err != nil {
+		return nil, err
+	}
+
+	return &ConsentRequest{
+		Challenge:         challenge,
+		Client:            req.Client.ClientID,
+		Subject:           req.Subject,
+		RequestedScope:    req.RequestedScope,
+		RequestedAudience: req.RequestedAudience,
+	}, nil
+}
+
+func (a *httpHydraAdmin) AcceptConsentRequest(challenge string, grantScope, grantAudience []string, session ConsentSession, remember bool) (string, error) {
+	return a.put("/oauth2/auth/requests/consent/accept?consent_challenge="+challenge, map[string]interface{}{
+		"grant_scope":                 grantScope,
+		"grant_access_token_audience": grantAudience,
+		"remember":                    remember,
+		"session": map[string]interface{}{
+			"id_token":     session.IDToken,
+			"access_token": session.AccessToken,
+		},
+	})
+}
+
+func (a *httpHydraAdmin) RejectConsentRequest(challenge, errorCode, errorDescription string) (string, error) {
+	return a.put("/oauth2/auth/requests/consent/reject?consent_challenge="+challenge, map[string]interface{}{
+		"error":             errorCode,
+		"error_description": errorDescription,
+	})
+}
+
+func (a *httpHydraAdmin) GetLogoutRequest(challenge string) (*LogoutRequest, error) {
+	var req hydraLogoutRequest
+	if err := a.get("/oauth2/auth/requests/logout?logout_challenge="+challenge, &req); err != nil {
+		return nil, err
+	}
+
+	return &LogoutRequest{Challenge: challenge, Subject: req.Subject}, nil
+}
+
+func (a *httpHydraAdmin) AcceptLogoutRequest(challenge string) (string, error) {
+	return a.put("/oauth2/auth/requests/logout/accept?logout_challenge="+challenge, map[string]interface{}{})
+}
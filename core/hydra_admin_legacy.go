@@ -0,0 +1,127 @@
+package core
+
+import (
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// legacyHydraAdmin implements HydraAdmin on top of the deprecated Hydra
+// 0.9 "/oauth2/consent/requests" flow: a single signed challenge JWT
+// standing in for both the login and consent steps, and a signed consent
+// JWT handed back to Hydra instead of calling an accept endpoint.
+//
+// It exists so deployments already running against Hydra 0.9 keep
+// working unchanged; new deployments should use NewHTTPHydraAdmin
+// against Hydra v1.x/v2.x instead.
+type legacyHydraAdmin struct {
+	idp *IDP
+}
+
+// newLegacyHydraAdmin wraps idp's existing challenge-JWT machinery as a
+// HydraAdmin.
+func newLegacyHydraAdmin(idp *IDP) HydraAdmin {
+	return &legacyHydraAdmin{idp: idp}
+}
+
+func (a *legacyHydraAdmin) decodeChallenge(challenge string) (*jwt.Token, error) {
+	return a.idp.getChallengeToken(challenge)
+}
+
+func (a *legacyHydraAdmin) GetLoginRequest(challenge string) (*LoginRequest, error) {
+	token, err := a.decodeChallenge(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+
+	return &LoginRequest{
+		Challenge:      challenge,
+		Client:         claims["aud"].(string),
+		RequestedScope: stringSlice(claims["scp"]),
+		// The 0.9 flow has no separate login step to skip.
+		Skip: false,
+	}, nil
+}
+
+// AcceptLoginRequest is a no-op in the 0.9 flow: there's no login accept
+// endpoint to call, so callers should proceed straight to
+// AcceptConsentRequest. The challenge is returned unchanged as
+// redirectTo.
+func (a *legacyHydraAdmin) AcceptLoginRequest(challenge, subject string, remember bool, acr string) (string, error) {
+	return challenge, nil
+}
+
+// RejectLoginRequest has no equivalent in the 0.9 flow.
+func (a *legacyHydraAdmin) RejectLoginRequest(challenge, errorCode, errorDescription string) (string, error) {
+	return "", ErrorNotSupported
+}
+
+func (a *legacyHydraAdmin) GetConsentRequest(challenge string) (*ConsentRequest, error) {
+	token, err := a.decodeChallenge(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+
+	return &ConsentRequest{
+		Challenge:      challenge,
+		Client:         claims["aud"].(string),
+		RequestedScope: stringSlice(claims["scp"]),
+	}, nil
+}
+
+// AcceptConsentRequest signs a consent JWT the way Hydra 0.9 expects and
+// returns the URL to redirect the user's browser back to Hydra with it.
+func (a *legacyHydraAdmin) AcceptConsentRequest(challenge string, grantScope, grantAudience []string, session ConsentSession, remember bool) (string, error) {
+	token, err := a.decodeChallenge(challenge)
+	if err != nil {
+		return "", err
+	}
+
+	client, _ := token.Claims.(jwt.MapClaims)["aud"].(string)
+	subject, _ := session.IDToken["sub"].(string)
+
+	extraClaims := make(map[string]interface{}, len(session.IDToken))
+	for k, v := range session.IDToken {
+		if k != "sub" {
+			extraClaims[k] = v
+		}
+	}
+
+	consent, err := a.idp.generateConsentToken(client, subject, grantScope, extraClaims)
+	if err != nil {
+		return "", err
+	}
+
+	return a.idp.config.HydraAddress + "/oauth2/auth?consent=" + consent, nil
+}
+
+// RejectConsentRequest has no equivalent in the 0.9 flow.
+func (a *legacyHydraAdmin) RejectConsentRequest(challenge, errorCode, errorDescription string) (string, error) {
+	return "", ErrorNotSupported
+}
+
+// GetLogoutRequest has no equivalent in the 0.9 flow.
+func (a *legacyHydraAdmin) GetLogoutRequest(challenge string) (*LogoutRequest, error) {
+	return nil, ErrorNotSupported
+}
+
+// AcceptLogoutRequest has no equivalent in the 0.9 flow.
+func (a *legacyHydraAdmin) AcceptLogoutRequest(challenge string) (string, error) {
+	return "", ErrorNotSupported
+}
+
+func stringSlice(v interface{}) []string {
+	raw, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	out := make([]string, len(raw))
+	for i, item := range raw {
+		out[i], _ = item.(string)
+	}
+
+	return out
+}
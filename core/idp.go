@@ -3,8 +3,11 @@ package core
 import (
 	"crypto/rsa"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/duo-labs/webauthn/webauthn"
 	"github.com/gorilla/sessions"
 	"github.com/mendsley/gojwk"
 	"github.com/patrickmn/go-cache"
@@ -23,6 +26,128 @@ type IDPConfig struct {
 	ClientSecret   string `yaml:"client_secret"`
 	HydraAddress   string `yaml:"token_endpoint"`
 	ChallengeStore sessions.Store
+
+	// RPID, RPDisplayName and RPOrigin configure the WebAuthn relying
+	// party used for passwordless login. RPOrigin must match the
+	// browser's origin exactly, including scheme and port.
+	RPID          string `yaml:"rp_id"`
+	RPDisplayName string `yaml:"rp_display_name"`
+	RPOrigin      string `yaml:"rp_origin"`
+
+	// UserVerificationRequired requires the authenticator to perform
+	// user verification (PIN, biometric, ...) during WebAuthn ceremonies.
+	UserVerificationRequired bool `yaml:"user_verification_required"`
+
+	// ResidentKeyRequired requests a discoverable (resident) credential,
+	// letting a user sign in without typing a username first.
+	ResidentKeyRequired bool `yaml:"resident_key_required"`
+
+	// CredentialStore persists registered WebAuthn credentials.
+	CredentialStore CredentialStore
+
+	// DeviceCodeStore persists in-flight device authorization requests
+	// for the OAuth2 Device Authorization Grant (RFC 8628).
+	DeviceCodeStore DeviceCodeStore
+
+	// DeviceVerificationURI is the user-facing page where a device_code
+	// gets paired to a user_code, returned as verification_uri in the
+	// POST /device/authorize response.
+	DeviceVerificationURI string `yaml:"device_verification_uri"`
+
+	// Connectors are the upstream identity providers end users can
+	// authenticate against, keyed by connector name (e.g. "ldap",
+	// "github").
+	Connectors map[string]Connector
+
+	// DefaultConnector is used when NewChallenge can't pick a connector
+	// from the client or ACR value.
+	DefaultConnector string `yaml:"default_connector"`
+
+	// ClientConnectors maps a client ID to the name of the connector
+	// that should authenticate its users.
+	ClientConnectors map[string]string `yaml:"client_connectors"`
+
+	// ACRConnectors maps an acr_values entry to the name of the
+	// connector it requests, e.g. "ldap" -> "ldap".
+	ACRConnectors map[string]string `yaml:"acr_connectors"`
+
+	// ScopeClaims maps a requested scope to the ID-token claims it
+	// unlocks, e.g. "profile" -> ["name", "given_name", "family_name"].
+	// Entries here extend/override defaultScopeClaims.
+	ScopeClaims map[string][]string `yaml:"scope_claims"`
+
+	// ClaimProvider fetches the claim values a scope maps to, keyed by
+	// subject. Required if any scope in ScopeClaims/defaultScopeClaims
+	// is actually requested.
+	ClaimProvider ClaimProvider
+
+	// ClientAudiences allow-lists, per client ID, which extra audiences
+	// (beyond the client itself) a "aud:<value>" scope may request.
+	ClientAudiences map[string][]string `yaml:"client_audiences"`
+
+	// ClientACR maps a client ID to the acr claim value its consent
+	// tokens should carry.
+	ClientACR map[string]string `yaml:"client_acr"`
+
+	// RejectUnknownScopes rejects a challenge outright if it requests a
+	// scope with no known mapping, instead of silently dropping it.
+	RejectUnknownScopes bool `yaml:"reject_unknown_scopes"`
+
+	// SessionStore persists authenticated user sessions, independently
+	// of the transient ChallengeStore.
+	SessionStore sessions.Store
+
+	// SessionIdleTTL and SessionAbsoluteTTL bound how long a user
+	// session can auto-approve challenges for: idle resets on every use,
+	// absolute is measured from authentication regardless of activity.
+	SessionIdleTTL     time.Duration `yaml:"session_idle_ttl"`
+	SessionAbsoluteTTL time.Duration `yaml:"session_absolute_ttl"`
+
+	// RememberMeTTL is how long a "remember me" cookie stays valid.
+	RememberMeTTL time.Duration `yaml:"remember_me_ttl"`
+
+	// RememberMeHashKey and RememberMeBlockKey sign/encrypt the
+	// remember-me cookie. Leave nil to generate an ephemeral key on
+	// startup (sessions won't survive a restart); set explicitly to
+	// rotate keys across a deployment.
+	RememberMeHashKey  []byte
+	RememberMeBlockKey []byte
+
+	// RegisteredClients lists the clients whose sessions must be
+	// notified on RP-Initiated Logout.
+	RegisteredClients []RegisteredClient
+
+	// HydraAdminAddress is the base URL of Hydra's admin API, used to
+	// revoke tokens on logout.
+	HydraAdminAddress string `yaml:"hydra_admin_address"`
+
+	// HydraAdmin abstracts the login/consent challenge API to use
+	// against Hydra. Leave nil to keep talking to the deprecated Hydra
+	// 0.9 consent-JWT flow; set to NewHTTPHydraAdmin(...) for Hydra
+	// v1.x/v2.x.
+	HydraAdmin HydraAdmin
+
+	// HydraTLSConfig is used as-is for the TLS connection to Hydra's
+	// token endpoint if set, taking precedence over
+	// HydraClientCertFile/HydraClientKeyFile/HydraCAFile.
+	HydraTLSConfig *tls.Config
+
+	// HydraClientCertFile and HydraClientKeyFile configure mTLS
+	// client-certificate authentication to Hydra's admin API.
+	HydraClientCertFile string `yaml:"hydra_client_cert_file"`
+	HydraClientKeyFile  string `yaml:"hydra_client_key_file"`
+
+	// HydraCAFile, if set, is used instead of the system trust store to
+	// verify Hydra's certificate.
+	HydraCAFile string `yaml:"hydra_ca_file"`
+
+	// ConsentKey signs the tokens this IDP mints on its own behalf:
+	// device-flow access tokens and the consent tokens
+	// CompleteConnectorLogin/FinishWebAuthnLogin return. It's required
+	// unless HydraAdmin is left nil, in which case Connect fetches the
+	// equivalent key from Hydra 0.9's deprecated consent.endpoint JWK
+	// endpoint instead.
+	ConsentKey *rsa.PrivateKey
 }
 
 type IDP struct {
@@ -37,6 +162,16 @@ type IDP struct {
 	// Key for signing the consent JWT
 	consentKey *rsa.PrivateKey
 	keyCache   *cache.Cache
+
+	// webauthn drives WebAuthn registration and login ceremonies.
+	webauthn *webauthn.WebAuthn
+
+	// sessions tracks authenticated users across challenges.
+	sessions *SessionManager
+
+	// hydraAdmin is the login/consent challenge API in use; see
+	// IDPConfig.HydraAdmin.
+	hydraAdmin HydraAdmin
 }
 
 func NewIDP(config *IDPConfig) *IDP {
@@ -45,9 +180,70 @@ func NewIDP(config *IDPConfig) *IDP {
 
 	// TODO: Pass TTL and refresh period from config
 	idp.keyCache = cache.New(5*time.Minute, 30*time.Second)
+
+	if config.CredentialStore != nil {
+		w, err := newWebAuthn(config)
+		if err != nil {
+			// RP configuration is validated by webauthn.New; a bad
+			// config is a programming error, not a runtime condition.
+			panic(err)
+		}
+		idp.webauthn = w
+	}
+
+	if config.SessionStore != nil {
+		idp.sessions = newSessionManager(idp, config)
+	}
+
+	idp.hydraAdmin = config.HydraAdmin
+	if idp.hydraAdmin == nil {
+		idp.hydraAdmin = newLegacyHydraAdmin(idp)
+	}
+
 	return idp
 }
 
+// LoginRequest fetches the login request behind challenge from Hydra.
+func (idp *IDP) LoginRequest(challenge string) (*LoginRequest, error) {
+	return idp.hydraAdmin.GetLoginRequest(challenge)
+}
+
+// AcceptLogin tells Hydra the subject authenticated for challenge.
+func (idp *IDP) AcceptLogin(challenge, subject string, remember bool, acr string) (string, error) {
+	return idp.hydraAdmin.AcceptLoginRequest(challenge, subject, remember, acr)
+}
+
+// RejectLogin tells Hydra the login for challenge failed.
+func (idp *IDP) RejectLogin(challenge, errorCode, errorDescription string) (string, error) {
+	return idp.hydraAdmin.RejectLoginRequest(challenge, errorCode, errorDescription)
+}
+
+// ConsentRequest fetches the consent request behind challenge from Hydra.
+func (idp *IDP) ConsentRequest(challenge string) (*ConsentRequest, error) {
+	return idp.hydraAdmin.GetConsentRequest(challenge)
+}
+
+// AcceptConsent tells Hydra the subject granted grantScope/grantAudience
+// for challenge, with session embedded in the resulting tokens.
+func (idp *IDP) AcceptConsent(challenge string, grantScope, grantAudience []string, session ConsentSession, remember bool) (string, error) {
+	return idp.hydraAdmin.AcceptConsentRequest(challenge, grantScope, grantAudience, session, remember)
+}
+
+// RejectConsent tells Hydra the consent for challenge was denied.
+func (idp *IDP) RejectConsent(challenge, errorCode, errorDescription string) (string, error) {
+	return idp.hydraAdmin.RejectConsentRequest(challenge, errorCode, errorDescription)
+}
+
+// LogoutRequest fetches the logout request behind challenge from Hydra.
+func (idp *IDP) LogoutRequest(challenge string) (*LogoutRequest, error) {
+	return idp.hydraAdmin.GetLogoutRequest(challenge)
+}
+
+// AcceptLogout tells Hydra to complete the logout for challenge.
+func (idp *IDP) AcceptLogout(challenge string) (string, error) {
+	return idp.hydraAdmin.AcceptLogoutRequest(challenge)
+}
+
 // Gets the requested key from Hydra
 func (idp *IDP) getKey(set string, kind string) (*gojwk.Key, error) {
 	url := idp.config.HydraAddress + "/keys/" + set + "/" + kind
@@ -105,6 +301,40 @@ func (idp *IDP) getConsentKey() error {
 	return err
 }
 
+// buildHydraTLSConfig builds the TLS client config used to talk to
+// Hydra's token and admin endpoints, honoring HydraTLSConfig verbatim if
+// set, otherwise assembling mTLS from HydraClientCertFile/KeyFile/CAFile.
+func buildHydraTLSConfig(config *IDPConfig) (*tls.Config, error) {
+	if config.HydraTLSConfig != nil {
+		return config.HydraTLSConfig, nil
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if config.HydraClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.HydraClientCertFile, config.HydraClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.HydraCAFile != "" {
+		ca, err := ioutil.ReadFile(config.HydraCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("failed to parse %s", config.HydraCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
 func (idp *IDP) login() error {
 	// Use the credentials to login to Hydra
 	credentials := clientcredentials.Config{
@@ -114,16 +344,19 @@ func (idp *IDP) login() error {
 		Scopes:       []string{"core", "hydra.keys.get"},
 	}
 
-	// Skip verifying the certificate
-	// TODO: Remove when Hydra implements passing key-cert pairs
+	tlsConfig, err := buildHydraTLSConfig(idp.config)
+	if err != nil {
+		return err
+	}
+
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsConfig,
 	}
 	c := &http.Client{Transport: tr}
 	ctx := context.WithValue(oauth2.NoContext, oauth2.HTTPClient, c)
 
 	// Prefetch the token - tests the connection``
-	_, err := credentials.Token(ctx)
+	_, err = credentials.Token(ctx)
 	if err != nil {
 		return err
 	}
@@ -134,22 +367,30 @@ func (idp *IDP) login() error {
 }
 
 func (idp *IDP) Connect() error {
-	err := idp.login()
-	if err != nil {
+	if err := idp.login(); err != nil {
 		return err
 	}
 
-	err = idp.getVerificationKey()
-	if err != nil {
-		return err
+	if idp.config.ConsentKey != nil {
+		idp.consentKey = idp.config.ConsentKey
 	}
 
-	err = idp.getConsentKey()
-	if err != nil {
+	if !idp.usesLegacyHydraAdmin() {
+		// Hydra v1.x/v2.x has no equivalent of the 0.9 consent.challenge
+		// and consent.endpoint JWK endpoints, so ConsentKey must be
+		// configured explicitly instead of fetched.
+		if idp.consentKey == nil {
+			return errors.New("core: IDPConfig.ConsentKey is required when HydraAdmin is set")
+		}
+
+		return nil
+	}
+
+	if err := idp.getVerificationKey(); err != nil {
 		return err
 	}
 
-	return err
+	return idp.getConsentKey()
 }
 
 // Parse and verify the challenge JWT
@@ -178,7 +419,30 @@ func (idp *IDP) GetConsentKey() (*rsa.PrivateKey, error) {
 	return idp.consentKey, nil
 }
 
-func (idp *IDP) NewChallenge(r *http.Request) (challenge *Challenge, err error) {
+// NewChallenge resolves the incoming request into a Challenge, reading
+// either Hydra 0.9's signed "challenge" JWT or a Hydra v1.x/v2.x
+// login_challenge/consent_challenge, depending on which HydraAdmin this
+// IDP is configured with.
+func (idp *IDP) NewChallenge(r *http.Request) (*Challenge, error) {
+	if idp.usesLegacyHydraAdmin() {
+		return idp.newLegacyChallenge(r)
+	}
+
+	return idp.newHydraChallenge(r)
+}
+
+// usesLegacyHydraAdmin reports whether idp is talking to Hydra through the
+// deprecated 0.9 consent-JWT flow rather than the v1.x/v2.x login+consent
+// challenge REST API, the one place the two flows require genuinely
+// different request parsing and key provisioning.
+func (idp *IDP) usesLegacyHydraAdmin() bool {
+	_, ok := idp.hydraAdmin.(*legacyHydraAdmin)
+	return ok
+}
+
+// newLegacyChallenge parses and verifies the signed challenge JWT Hydra
+// 0.9 passes as the "challenge" form value.
+func (idp *IDP) newLegacyChallenge(r *http.Request) (challenge *Challenge, err error) {
 	tokenStr := r.FormValue("challenge")
 	if tokenStr == "" {
 		// No challenge token
@@ -193,6 +457,7 @@ func (idp *IDP) NewChallenge(r *http.Request) (challenge *Challenge, err error)
 
 	challenge = new(Challenge)
 	challenge.idp = idp
+	challenge.token = token
 
 	// Get data from the challenge jwt
 	claims := token.Claims.(jwt.MapClaims)
@@ -206,9 +471,125 @@ func (idp *IDP) NewChallenge(r *http.Request) (challenge *Challenge, err error)
 		challenge.Scopes[i] = scope.(string)
 	}
 
+	acr, _ := claims["acr"].(string)
+	connectorName, err := idp.resolveConnector(challenge.Client, acr, r.FormValue("connector"))
+	if err != nil {
+		return
+	}
+	challenge.Connector = connectorName
+
 	return
 }
 
+// newHydraChallenge resolves a Hydra v1.x/v2.x login_challenge or
+// consent_challenge query parameter against idp.hydraAdmin.
+func (idp *IDP) newHydraChallenge(r *http.Request) (*Challenge, error) {
+	if loginChallenge := r.FormValue("login_challenge"); loginChallenge != "" {
+		req, err := idp.hydraAdmin.GetLoginRequest(loginChallenge)
+		if err != nil {
+			return nil, err
+		}
+
+		return idp.newHydraChallengeFrom(loginChallenge, req.Client, req.RequestedScope, r)
+	}
+
+	consentChallenge := r.FormValue("consent_challenge")
+	if consentChallenge == "" {
+		return nil, ErrorBadRequest
+	}
+
+	req, err := idp.hydraAdmin.GetConsentRequest(consentChallenge)
+	if err != nil {
+		return nil, err
+	}
+
+	return idp.newHydraChallengeFrom(consentChallenge, req.Client, req.RequestedScope, r)
+}
+
+// newHydraChallengeFrom builds the Challenge common to both the login and
+// consent steps of the Hydra v1.x/v2.x flow.
+func (idp *IDP) newHydraChallengeFrom(id, client string, requestedScope []string, r *http.Request) (*Challenge, error) {
+	connectorName, err := idp.resolveConnector(client, "", r.FormValue("connector"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Challenge{
+		idp:       idp,
+		ID:        id,
+		Client:    client,
+		Scopes:    requestedScope,
+		Connector: connectorName,
+	}, nil
+}
+
+// resolveConnector picks which Connector should authenticate the end user
+// for this challenge, in order of: an explicit hint (e.g. a "connector"
+// query parameter or user-typed login_hint), the acr_values requested by
+// the client, the client's configured connector, and finally
+// DefaultConnector.
+func (idp *IDP) resolveConnector(client, acr, hint string) (string, error) {
+	if hint != "" {
+		if _, ok := idp.config.Connectors[hint]; ok {
+			return hint, nil
+		}
+	}
+
+	if acr != "" {
+		if name, ok := idp.config.ACRConnectors[acr]; ok {
+			return name, nil
+		}
+	}
+
+	if name, ok := idp.config.ClientConnectors[client]; ok {
+		return name, nil
+	}
+
+	if idp.config.DefaultConnector != "" {
+		return idp.config.DefaultConnector, nil
+	}
+
+	return "", ErrorUnknownConnector
+}
+
+// Connector returns the upstream identity provider resolved for
+// challenge, so handlers can start or continue its login flow.
+func (idp *IDP) Connector(challenge *Challenge) (Connector, error) {
+	connector, ok := idp.config.Connectors[challenge.Connector]
+	if !ok {
+		return nil, ErrorUnknownConnector
+	}
+
+	return connector, nil
+}
+
+// CompleteConnectorLogin finishes the upstream login started by
+// Connector(challenge).LoginURL, turning the resulting Identity into a
+// signed consent token.
+func (idp *IDP) CompleteConnectorLogin(challenge *Challenge, r *http.Request) (string, error) {
+	connector, err := idp.Connector(challenge)
+	if err != nil {
+		return "", err
+	}
+
+	identity, err := connector.HandleCallback(r)
+	if err != nil {
+		return "", err
+	}
+
+	tokenScopes, claims, err := idp.buildConsentClaims(challenge.Client, identity.Subject, challenge.Scopes)
+	if err != nil {
+		return "", err
+	}
+
+	claims["amr"] = []string{challenge.Connector}
+	for k, v := range identity.claims() {
+		claims[k] = v
+	}
+
+	return idp.generateConsentToken(challenge.Client, identity.Subject, tokenScopes, claims)
+}
+
 func (idp *IDP) GetChallenge(r *http.Request) (*Challenge, error) {
 	session, err := idp.config.ChallengeStore.Get(r, SessionCookieName)
 	if err != nil {
@@ -225,13 +606,23 @@ func (idp *IDP) GetChallenge(r *http.Request) (*Challenge, error) {
 	return challenge, nil
 }
 
-// Generate the consent
-func (idp *IDP) generateConsentToken(challenge *jwt.Token, subject string, scopes []string) (string, error) {
+// Generate the consent. extraClaims, if given, are merged into the token
+// on top of aud/exp/iat/scp/sub, e.g. the email/groups/raw claims carried
+// by a Connector's Identity. client is used as the token's aud unless
+// extraClaims already set one.
+func (idp *IDP) generateConsentToken(client, subject string, scopes []string, extraClaims ...map[string]interface{}) (string, error) {
 	now := time.Now()
 
 	token := jwt.New(jwt.SigningMethodRS256)
 	claims := token.Claims.(jwt.MapClaims)
-	claims["aud"] = challenge.Claims.(jwt.MapClaims)["aud"]
+	for _, extra := range extraClaims {
+		for k, v := range extra {
+			claims[k] = v
+		}
+	}
+	if _, ok := claims["aud"]; !ok {
+		claims["aud"] = client
+	}
 	claims["exp"] = now.Add(time.Minute * 5).Unix()
 	claims["iat"] = now.Unix()
 	claims["scp"] = scopes
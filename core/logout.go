@@ -0,0 +1,137 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// RegisteredClient is the subset of a Hydra client's metadata this IDP
+// needs to fan a logout out to it.
+type RegisteredClient struct {
+	ClientID              string
+	FrontchannelLogoutURI string
+	BackchannelLogoutURI  string
+}
+
+// backchannelLogoutEventClaim is the claim OIDC Back-Channel Logout uses
+// to mark a logout token apart from a regular ID token.
+const backchannelLogoutEventClaim = "http://schemas.openid.net/event/backchannel-logout"
+
+// Logout implements RP-Initiated Logout (OIDC end_session): it terminates
+// the local session, revokes the subject's tokens against Hydra's admin
+// API, and notifies every registered client's front/back-channel logout
+// endpoint.
+func (idp *IDP) Logout(w http.ResponseWriter, r *http.Request) error {
+	if idp.sessions == nil {
+		return nil
+	}
+
+	session, err := idp.sessions.Session(w, r)
+	if err != nil && err != ErrorNoSession && err != ErrorSessionExpired {
+		return err
+	}
+
+	if err := idp.sessions.Destroy(w, r); err != nil {
+		return err
+	}
+
+	if session == nil {
+		return nil
+	}
+
+	if err := idp.revokeHydraTokens(session.Subject); err != nil {
+		return err
+	}
+
+	for _, client := range idp.config.RegisteredClients {
+		idp.notifyBackchannelLogout(client, session.Subject)
+	}
+
+	return nil
+}
+
+// FrontchannelLogoutURIs returns the frontchannel_logout_uri of every
+// registered client, for handlers to render as hidden iframes on the
+// post-logout page.
+func (idp *IDP) FrontchannelLogoutURIs() []string {
+	var uris []string
+	for _, client := range idp.config.RegisteredClients {
+		if client.FrontchannelLogoutURI != "" {
+			uris = append(uris, client.FrontchannelLogoutURI)
+		}
+	}
+
+	return uris
+}
+
+// revokeHydraTokens asks Hydra's admin API to revoke every consent
+// session it holds for subject.
+func (idp *IDP) revokeHydraTokens(subject string) error {
+	query := url.Values{"subject": {subject}}
+	req, err := http.NewRequest(http.MethodDelete, idp.config.HydraAdminAddress+"/oauth2/auth/sessions/consent?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := idp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// notifyBackchannelLogout delivers a signed logout token to client's
+// backchannel_logout_uri, per the OIDC Back-Channel Logout spec. Errors
+// are not fatal to the overall Logout call: a single unreachable RP
+// shouldn't stop the others from being notified.
+func (idp *IDP) notifyBackchannelLogout(client RegisteredClient, subject string) {
+	if client.BackchannelLogoutURI == "" {
+		return
+	}
+
+	logoutToken, err := idp.signLogoutToken(client.ClientID, subject)
+	if err != nil {
+		return
+	}
+
+	body, err := json.Marshal(map[string]string{"logout_token": logoutToken})
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, client.BackchannelLogoutURI, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := idp.client.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+// signLogoutToken builds and signs the logout token sent to a client's
+// back-channel logout endpoint, carrying the OIDC Back-Channel Logout
+// "events" claim.
+func (idp *IDP) signLogoutToken(clientID, subject string) (string, error) {
+	now := time.Now()
+
+	token := jwt.New(jwt.SigningMethodRS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["aud"] = clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Minute).Unix()
+	claims["sub"] = subject
+	claims["events"] = map[string]interface{}{backchannelLogoutEventClaim: struct{}{}}
+
+	return token.SignedString(idp.consentKey)
+}
@@ -0,0 +1,251 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// UserSessionCookieName is the cookie holding the authenticated user's
+// session, separate from the transient challenge cookie
+// (SessionCookieName).
+const UserSessionCookieName = "idp_session"
+
+// RememberMeCookieName is the long-lived, separately signed cookie that
+// lets CreateSession re-establish a UserSession after the regular session
+// cookie has expired or been cleared.
+const RememberMeCookieName = "idp_remember_me"
+
+// ErrorNoSession is returned when no valid user session exists for a
+// request.
+var ErrorNoSession = errors.New("no user session")
+
+// ErrorSessionExpired is returned when a user session has outlived its
+// idle or absolute TTL.
+var ErrorSessionExpired = errors.New("user session expired")
+
+// UserSession is a subject authenticated independently of any particular
+// Hydra challenge, persisted so NewChallenge can auto-approve later
+// challenges for the same subject within the SSO window.
+type UserSession struct {
+	Subject   string
+	Connector string
+
+	AuthenticatedAt time.Time
+	LastSeenAt      time.Time
+	ExpiresAt       time.Time
+	Remember        bool
+}
+
+func (s *UserSession) expired(idleTTL, absoluteTTL time.Duration) bool {
+	now := time.Now()
+	if now.After(s.ExpiresAt) {
+		return true
+	}
+
+	if absoluteTTL > 0 && now.Sub(s.AuthenticatedAt) > absoluteTTL {
+		return true
+	}
+
+	if idleTTL > 0 && now.Sub(s.LastSeenAt) > idleTTL {
+		return true
+	}
+
+	return false
+}
+
+// rememberMeToken is what RememberMeCookieName carries, signed (and
+// optionally encrypted) with a rotating key so it can't be forged or read
+// by the browser.
+type rememberMeToken struct {
+	Subject   string
+	Connector string
+}
+
+// SessionManager persists authenticated user sessions independently of
+// the transient Hydra challenge cookie, backed by the same sessions.Store
+// abstraction the challenge cookie already uses.
+type SessionManager struct {
+	idp *IDP
+
+	idleTTL     time.Duration
+	absoluteTTL time.Duration
+	rememberTTL time.Duration
+	rememberKey *securecookie.SecureCookie
+}
+
+// newSessionManager builds the SessionManager for config. The remember-me
+// signing key is rotated by restarting the IDP with
+// RememberMeHashKey/RememberMeBlockKey set explicitly instead of left nil.
+func newSessionManager(idp *IDP, config *IDPConfig) *SessionManager {
+	hashKey := config.RememberMeHashKey
+	if hashKey == nil {
+		hashKey = securecookie.GenerateRandomKey(64)
+	}
+
+	blockKey := config.RememberMeBlockKey
+	if blockKey == nil {
+		blockKey = securecookie.GenerateRandomKey(32)
+	}
+
+	idleTTL := config.SessionIdleTTL
+	if idleTTL == 0 {
+		idleTTL = 30 * time.Minute
+	}
+
+	rememberTTL := config.RememberMeTTL
+	if rememberTTL == 0 {
+		rememberTTL = 30 * 24 * time.Hour
+	}
+
+	return &SessionManager{
+		idp:         idp,
+		idleTTL:     idleTTL,
+		absoluteTTL: config.SessionAbsoluteTTL,
+		rememberTTL: rememberTTL,
+		rememberKey: securecookie.New(hashKey, blockKey),
+	}
+}
+
+// CreateSession authenticates subject for a new UserSession, persisting it
+// to the configured SessionStore and writing its cookie to w. If remember
+// is set, a long-lived signed cookie is also written so the session can
+// be re-established after the regular session cookie expires.
+func (m *SessionManager) CreateSession(w http.ResponseWriter, r *http.Request, subject, connector string, remember bool) error {
+	_, err := m.createSession(w, r, subject, connector, remember)
+	return err
+}
+
+// createSession is CreateSession's implementation, also returning the
+// UserSession it just persisted so Session can hand it back to the caller
+// directly instead of re-reading it from a *http.Request that, having
+// been created before CreateSession ran, doesn't carry the cookie
+// CreateSession just wrote to w.
+func (m *SessionManager) createSession(w http.ResponseWriter, r *http.Request, subject, connector string, remember bool) (*UserSession, error) {
+	now := time.Now()
+
+	ttl := m.idleTTL
+	if m.absoluteTTL > 0 && m.absoluteTTL < ttl {
+		ttl = m.absoluteTTL
+	}
+
+	session, err := m.idp.config.SessionStore.New(r, UserSessionCookieName)
+	if err != nil {
+		return nil, err
+	}
+
+	userSession := &UserSession{
+		Subject:         subject,
+		Connector:       connector,
+		AuthenticatedAt: now,
+		LastSeenAt:      now,
+		ExpiresAt:       now.Add(ttl),
+		Remember:        remember,
+	}
+	session.Values[UserSessionCookieName] = userSession
+	session.Options.MaxAge = int(ttl.Seconds())
+
+	if err := session.Save(r, w); err != nil {
+		return nil, err
+	}
+
+	if !remember {
+		return userSession, nil
+	}
+
+	encoded, err := m.rememberKey.Encode(RememberMeCookieName, &rememberMeToken{Subject: subject, Connector: connector})
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     RememberMeCookieName,
+		Value:    encoded,
+		Path:     "/",
+		MaxAge:   int(m.rememberTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return userSession, nil
+}
+
+// Session returns the UserSession carried by r, re-establishing it from
+// the remember-me cookie if the regular session has expired or been
+// cleared but a valid remember-me cookie is still present.
+func (m *SessionManager) Session(w http.ResponseWriter, r *http.Request) (*UserSession, error) {
+	session, err := m.idp.config.SessionStore.Get(r, UserSessionCookieName)
+	if err == nil {
+		if userSession, ok := session.Values[UserSessionCookieName].(*UserSession); ok {
+			if userSession.expired(m.idleTTL, m.absoluteTTL) {
+				return nil, ErrorSessionExpired
+			}
+
+			userSession.LastSeenAt = time.Now()
+			session.Values[UserSessionCookieName] = userSession
+			session.Save(r, w)
+
+			return userSession, nil
+		}
+	}
+
+	cookie, err := r.Cookie(RememberMeCookieName)
+	if err != nil {
+		return nil, ErrorNoSession
+	}
+
+	var remembered rememberMeToken
+	if err := m.rememberKey.Decode(RememberMeCookieName, cookie.Value, &remembered); err != nil {
+		return nil, ErrorNoSession
+	}
+
+	return m.createSession(w, r, remembered.Subject, remembered.Connector, true)
+}
+
+// Destroy terminates the local session: it clears the session cookie and
+// the remember-me cookie on w.
+func (m *SessionManager) Destroy(w http.ResponseWriter, r *http.Request) error {
+	session, err := m.idp.config.SessionStore.Get(r, UserSessionCookieName)
+	if err == nil {
+		session.Options.MaxAge = -1
+		if err := session.Save(r, w); err != nil {
+			return err
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     RememberMeCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// TryAutoApprove checks for an existing user session within the SSO
+// window and, if one exists for challenge's subject, returns a signed
+// consent token without involving the end user.
+func (idp *IDP) TryAutoApprove(w http.ResponseWriter, r *http.Request, challenge *Challenge) (token string, ok bool, err error) {
+	if idp.sessions == nil {
+		return "", false, nil
+	}
+
+	session, err := idp.sessions.Session(w, r)
+	if err != nil {
+		return "", false, nil
+	}
+
+	token, err = idp.GenerateConsentToken(challenge, session.Subject, session.Connector)
+	if err != nil {
+		return "", false, err
+	}
+
+	return token, true, nil
+}
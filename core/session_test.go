@@ -0,0 +1,114 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func newTestSessionManager() *SessionManager {
+	idp := &IDP{config: &IDPConfig{
+		SessionStore: sessions.NewCookieStore([]byte("0123456789012345678901234567890123456789")),
+	}}
+
+	return newSessionManager(idp, idp.config)
+}
+
+// TestSessionRememberMeReestablishesSession guards against the regression
+// where, with only a remember-me cookie present, Session recursed into
+// itself forever instead of returning the freshly re-established
+// UserSession.
+func TestSessionRememberMeReestablishesSession(t *testing.T) {
+	m := newTestSessionManager()
+
+	w1 := httptest.NewRecorder()
+	r1 := httptest.NewRequest("GET", "/", nil)
+
+	if err := m.CreateSession(w1, r1, "alice", "ldap", true); err != nil {
+		t.Fatalf("CreateSession returned error: %v", err)
+	}
+
+	var rememberCookie *http.Cookie
+	for _, c := range w1.Result().Cookies() {
+		if c.Name == RememberMeCookieName {
+			rememberCookie = c
+		}
+	}
+	if rememberCookie == nil {
+		t.Fatal("CreateSession didn't write a remember-me cookie")
+	}
+
+	// Simulate the browser having kept only the remember-me cookie: the
+	// regular session cookie is missing/expired.
+	r2 := httptest.NewRequest("GET", "/", nil)
+	r2.AddCookie(rememberCookie)
+	w2 := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	var session *UserSession
+	var err error
+	go func() {
+		session, err = m.Session(w2, r2)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Session did not return, likely recursing forever")
+	}
+
+	if err != nil {
+		t.Fatalf("Session returned error: %v", err)
+	}
+	if session.Subject != "alice" || session.Connector != "ldap" {
+		t.Errorf("session = %+v, want Subject=alice Connector=ldap", session)
+	}
+}
+
+func TestUserSessionExpired(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		session     UserSession
+		idleTTL     time.Duration
+		absoluteTTL time.Duration
+		want        bool
+	}{
+		{
+			name:    "not expired",
+			session: UserSession{AuthenticatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)},
+			idleTTL: time.Hour,
+			want:    false,
+		},
+		{
+			name:    "past ExpiresAt",
+			session: UserSession{AuthenticatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(-time.Minute)},
+			want:    true,
+		},
+		{
+			name:        "past absolute TTL",
+			session:     UserSession{AuthenticatedAt: now.Add(-2 * time.Hour), LastSeenAt: now, ExpiresAt: now.Add(time.Hour)},
+			absoluteTTL: time.Hour,
+			want:        true,
+		},
+		{
+			name:    "past idle TTL",
+			session: UserSession{AuthenticatedAt: now, LastSeenAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+			idleTTL: time.Minute,
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.session.expired(tt.idleTTL, tt.absoluteTTL); got != tt.want {
+				t.Errorf("expired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
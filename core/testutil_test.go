@@ -0,0 +1,25 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// httptestPostForm builds a POST request with values url-encoded into
+// the body, as a browser submitting an HTML form would.
+func httptestPostForm(t *testing.T, values map[string]string) *http.Request {
+	t.Helper()
+
+	form := url.Values{}
+	for k, v := range values {
+		form.Set(k, v)
+	}
+
+	r := httptest.NewRequest("POST", "/callback", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return r
+}
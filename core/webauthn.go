@@ -0,0 +1,461 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/duo-labs/webauthn/protocol"
+	"github.com/duo-labs/webauthn/webauthn"
+	"github.com/go-redis/redis"
+)
+
+// ErrorUnknownCredential is returned when a CredentialStore can't find a
+// credential for the given user or credential ID.
+var ErrorUnknownCredential = errors.New("unknown webauthn credential")
+
+// Credential is the subset of a WebAuthn credential that needs to survive
+// across requests, persisted by a CredentialStore.
+type Credential struct {
+	UserID       string
+	CredentialID []byte
+	PublicKey    []byte
+	SignCount    uint32
+	AAGUID       []byte
+	Transports   []string
+}
+
+// CredentialStore persists WebAuthn credentials so that a registration
+// ceremony performed on one request can be verified against on a later,
+// unrelated request.
+type CredentialStore interface {
+	CredentialsForUser(userID string) ([]Credential, error)
+	SaveCredential(cred Credential) error
+	UpdateSignCount(credentialID []byte, signCount uint32) error
+}
+
+// memoryCredentialStore is a CredentialStore backed by an in-process map.
+// It's mainly useful for development and tests; nothing is persisted
+// across restarts.
+type memoryCredentialStore struct {
+	mu     sync.Mutex
+	byUser map[string][]Credential
+}
+
+// NewMemoryCredentialStore returns a CredentialStore that keeps credentials
+// in memory.
+func NewMemoryCredentialStore() CredentialStore {
+	return &memoryCredentialStore{byUser: make(map[string][]Credential)}
+}
+
+func (s *memoryCredentialStore) CredentialsForUser(userID string) ([]Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.byUser[userID], nil
+}
+
+func (s *memoryCredentialStore) SaveCredential(cred Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.byUser[cred.UserID] = append(s.byUser[cred.UserID], cred)
+	return nil
+}
+
+func (s *memoryCredentialStore) UpdateSignCount(credentialID []byte, signCount uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for user, creds := range s.byUser {
+		for i, cred := range creds {
+			if string(cred.CredentialID) == string(credentialID) {
+				s.byUser[user][i].SignCount = signCount
+				return nil
+			}
+		}
+	}
+
+	return ErrorUnknownCredential
+}
+
+// sqlCredentialStore is a CredentialStore backed by a SQL database, using
+// the same "webauthn_credentials" table layout as the other relational
+// stores in this package.
+type sqlCredentialStore struct {
+	db *sql.DB
+}
+
+// NewSQLCredentialStore returns a CredentialStore backed by db. The
+// "webauthn_credentials" table is expected to already exist.
+func NewSQLCredentialStore(db *sql.DB) CredentialStore {
+	return &sqlCredentialStore{db: db}
+}
+
+func (s *sqlCredentialStore) CredentialsForUser(userID string) ([]Credential, error) {
+	rows, err := s.db.Query(`SELECT credential_id, public_key, sign_count, aaguid, transports
+		FROM webauthn_credentials WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []Credential
+	for rows.Next() {
+		cred := Credential{UserID: userID}
+		var transports string
+		if err := rows.Scan(&cred.CredentialID, &cred.PublicKey, &cred.SignCount, &cred.AAGUID, &transports); err != nil {
+			return nil, err
+		}
+		cred.Transports = splitTransports(transports)
+		creds = append(creds, cred)
+	}
+
+	return creds, rows.Err()
+}
+
+func (s *sqlCredentialStore) SaveCredential(cred Credential) error {
+	_, err := s.db.Exec(`INSERT INTO webauthn_credentials
+		(user_id, credential_id, public_key, sign_count, aaguid, transports)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		cred.UserID, cred.CredentialID, cred.PublicKey, cred.SignCount, cred.AAGUID, joinTransports(cred.Transports))
+	return err
+}
+
+func (s *sqlCredentialStore) UpdateSignCount(credentialID []byte, signCount uint32) error {
+	res, err := s.db.Exec(`UPDATE webauthn_credentials SET sign_count = ? WHERE credential_id = ?`, signCount, credentialID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrorUnknownCredential
+	}
+
+	return nil
+}
+
+func splitTransports(transports string) []string {
+	if transports == "" {
+		return nil
+	}
+
+	var out []string
+	start := 0
+	for i := 0; i <= len(transports); i++ {
+		if i == len(transports) || transports[i] == ',' {
+			out = append(out, transports[start:i])
+			start = i + 1
+		}
+	}
+
+	return out
+}
+
+func joinTransports(transports []string) string {
+	out := ""
+	for i, t := range transports {
+		if i > 0 {
+			out += ","
+		}
+		out += t
+	}
+
+	return out
+}
+
+// redisCredentialStore is a CredentialStore backed by Redis, storing the
+// credentials for each user as a single serialized list under a
+// "webauthn:<userID>" key.
+type redisCredentialStore struct {
+	client *redis.Client
+}
+
+// NewRedisCredentialStore returns a CredentialStore backed by client.
+func NewRedisCredentialStore(client *redis.Client) CredentialStore {
+	return &redisCredentialStore{client: client}
+}
+
+func (s *redisCredentialStore) key(userID string) string {
+	return "webauthn:" + userID
+}
+
+func (s *redisCredentialStore) CredentialsForUser(userID string) ([]Credential, error) {
+	raw, err := s.client.LRange(s.key(userID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	creds := make([]Credential, len(raw))
+	for i, entry := range raw {
+		if err := json.Unmarshal([]byte(entry), &creds[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return creds, nil
+}
+
+func (s *redisCredentialStore) SaveCredential(cred Credential) error {
+	encoded, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+
+	return s.client.RPush(s.key(cred.UserID), encoded).Err()
+}
+
+func (s *redisCredentialStore) UpdateSignCount(credentialID []byte, signCount uint32) error {
+	var cursor uint64
+	for {
+		keys, next, err := s.client.Scan(cursor, "webauthn:*", 100).Result()
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			raw, err := s.client.LRange(key, 0, -1).Result()
+			if err != nil {
+				return err
+			}
+
+			for i, entry := range raw {
+				var cred Credential
+				if err := json.Unmarshal([]byte(entry), &cred); err != nil {
+					return err
+				}
+
+				if string(cred.CredentialID) != string(credentialID) {
+					continue
+				}
+
+				cred.SignCount = signCount
+				encoded, err := json.Marshal(cred)
+				if err != nil {
+					return err
+				}
+
+				return s.client.LSet(key, int64(i), encoded).Err()
+			}
+		}
+
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	return ErrorUnknownCredential
+}
+
+// webauthnUser adapts a subject/credential pair to the webauthn.User
+// interface expected by github.com/duo-labs/webauthn.
+type webauthnUser struct {
+	id          string
+	name        string
+	credentials []Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte          { return []byte(u.id) }
+func (u *webauthnUser) WebAuthnName() string        { return u.name }
+func (u *webauthnUser) WebAuthnDisplayName() string { return u.name }
+func (u *webauthnUser) WebAuthnIcon() string        { return "" }
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	out := make([]webauthn.Credential, len(u.credentials))
+	for i, cred := range u.credentials {
+		out[i] = webauthn.Credential{
+			ID:              cred.CredentialID,
+			PublicKey:       cred.PublicKey,
+			AttestationType: "",
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    cred.AAGUID,
+				SignCount: cred.SignCount,
+			},
+		}
+	}
+
+	return out
+}
+
+// BeginWebAuthnRegistration starts a credential registration ceremony for
+// userID, returning the attestation options to send to the browser.
+func (idp *IDP) BeginWebAuthnRegistration(userID, username string) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	creds, err := idp.config.CredentialStore.CredentialsForUser(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user := &webauthnUser{id: userID, name: username, credentials: creds}
+
+	uv := protocol.VerificationPreferred
+	if idp.config.UserVerificationRequired {
+		uv = protocol.VerificationRequired
+	}
+
+	opts := make([]webauthn.RegistrationOption, 0, 1)
+	if idp.config.ResidentKeyRequired {
+		opts = append(opts, webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			UserVerification:   uv,
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+		}))
+	}
+
+	return idp.webauthn.BeginRegistration(user, opts...)
+}
+
+// FinishWebAuthnRegistration verifies the attestation response in r and
+// persists the resulting credential for userID.
+func (idp *IDP) FinishWebAuthnRegistration(userID, username string, sessionData *webauthn.SessionData, r *http.Request) error {
+	creds, err := idp.config.CredentialStore.CredentialsForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	user := &webauthnUser{id: userID, name: username, credentials: creds}
+
+	credential, err := idp.webauthn.FinishRegistration(user, *sessionData, r)
+	if err != nil {
+		return err
+	}
+
+	return idp.config.CredentialStore.SaveCredential(Credential{
+		UserID:       userID,
+		CredentialID: credential.ID,
+		PublicKey:    credential.PublicKey,
+		SignCount:    credential.Authenticator.SignCount,
+		AAGUID:       credential.Authenticator.AAGUID,
+	})
+}
+
+// BeginWebAuthnLogin starts an assertion ceremony for userID, returning the
+// assertion options to send to the browser.
+func (idp *IDP) BeginWebAuthnLogin(userID, username string) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	creds, err := idp.config.CredentialStore.CredentialsForUser(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(creds) == 0 {
+		return nil, nil, ErrorUnknownCredential
+	}
+
+	user := &webauthnUser{id: userID, name: username, credentials: creds}
+
+	return idp.webauthn.BeginLogin(user, webauthn.WithUserVerification(idp.userVerification()))
+}
+
+// BeginDiscoverableWebAuthnLogin starts an assertion ceremony without
+// knowing the user up front, for a resident (discoverable) credential
+// registered with ResidentKeyRequired set. It passes an empty allow
+// list so the authenticator itself presents whichever resident
+// credential the end user picks; FinishDiscoverableWebAuthnLogin then
+// identifies the user from the assertion's user handle.
+func (idp *IDP) BeginDiscoverableWebAuthnLogin() (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	user := &webauthnUser{}
+
+	return idp.webauthn.BeginLogin(user, webauthn.WithUserVerification(idp.userVerification()))
+}
+
+func (idp *IDP) userVerification() protocol.UserVerificationRequirement {
+	if idp.config.UserVerificationRequired {
+		return protocol.VerificationRequired
+	}
+
+	return protocol.VerificationPreferred
+}
+
+// FinishWebAuthnLogin verifies the assertion response in r and, on success,
+// generates the consent JWT that completes challenge without a password.
+func (idp *IDP) FinishWebAuthnLogin(challenge *Challenge, userID, username string, sessionData *webauthn.SessionData, r *http.Request) (string, error) {
+	creds, err := idp.config.CredentialStore.CredentialsForUser(userID)
+	if err != nil {
+		return "", err
+	}
+
+	user := &webauthnUser{id: userID, name: username, credentials: creds}
+
+	credential, err := idp.webauthn.FinishLogin(user, *sessionData, r)
+	if err != nil {
+		return "", err
+	}
+
+	if err := idp.config.CredentialStore.UpdateSignCount(credential.ID, credential.Authenticator.SignCount); err != nil {
+		return "", err
+	}
+
+	return idp.GenerateConsentToken(challenge, userID, "webauthn")
+}
+
+// FinishDiscoverableWebAuthnLogin verifies the assertion response in r for
+// a login started with BeginDiscoverableWebAuthnLogin, resolving the user
+// from the assertion's user handle instead of a userID known up front.
+func (idp *IDP) FinishDiscoverableWebAuthnLogin(challenge *Challenge, sessionData *webauthn.SessionData, r *http.Request) (string, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	r.Body.Close()
+
+	// protocol.ParseCredentialRequestResponse and webauthn.FinishLogin
+	// below each read r.Body in full, so it has to be replayed between
+	// the two.
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	parsedResponse, err := protocol.ParseCredentialRequestResponse(r)
+	if err != nil {
+		return "", err
+	}
+
+	userID := string(parsedResponse.Response.UserHandle)
+	if userID == "" {
+		return "", ErrorUnknownCredential
+	}
+
+	creds, err := idp.config.CredentialStore.CredentialsForUser(userID)
+	if err != nil {
+		return "", err
+	}
+
+	if len(creds) == 0 {
+		return "", ErrorUnknownCredential
+	}
+
+	user := &webauthnUser{id: userID, credentials: creds}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	credential, err := idp.webauthn.FinishLogin(user, *sessionData, r)
+	if err != nil {
+		return "", err
+	}
+
+	if err := idp.config.CredentialStore.UpdateSignCount(credential.ID, credential.Authenticator.SignCount); err != nil {
+		return "", err
+	}
+
+	return idp.GenerateConsentToken(challenge, userID, "webauthn")
+}
+
+// newWebAuthn builds the github.com/duo-labs/webauthn instance used for
+// both registration and login ceremonies, from the RP settings in config.
+func newWebAuthn(config *IDPConfig) (*webauthn.WebAuthn, error) {
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          config.RPID,
+		RPDisplayName: config.RPDisplayName,
+		RPOrigin:      config.RPOrigin,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure webauthn: %w", err)
+	}
+
+	return w, nil
+}
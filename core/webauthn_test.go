@@ -0,0 +1,19 @@
+package core
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/duo-labs/webauthn/webauthn"
+)
+
+func TestFinishDiscoverableWebAuthnLoginRejectsMalformedAssertion(t *testing.T) {
+	idp := &IDP{config: &IDPConfig{}}
+
+	r := httptest.NewRequest("POST", "/webauthn/login/finish", strings.NewReader("not a credential assertion response"))
+
+	if _, err := idp.FinishDiscoverableWebAuthnLogin(&Challenge{}, &webauthn.SessionData{}, r); err == nil {
+		t.Error("expected an error for a malformed assertion response, got nil")
+	}
+}